@@ -4,26 +4,42 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/thegreenfieldoverride/liberation-analytics/auth/scope"
 )
 
 // APIToken represents an API token for accessing protected endpoints
 type APIToken struct {
-	ID          string     `json:"id"`
-	TokenHash   string     `json:"-"`
-	Name        string     `json:"name"`
-	Permissions []string   `json:"permissions"`
-	CreatedAt   time.Time  `json:"created_at"`
-	LastUsed    *time.Time `json:"last_used,omitempty"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
-	IsActive    bool       `json:"is_active"`
+	ID                 string     `json:"id"`
+	TokenHash          string     `json:"-"`
+	Name               string     `json:"name"`
+	Permissions        []string   `json:"permissions"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsed           *time.Time `json:"last_used,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	AccessExpiresAt    *time.Time `json:"access_expires_at,omitempty"`
+	RefreshTokenHash   string     `json:"-"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute,omitempty"`
+	DailyQuota         int        `json:"daily_quota,omitempty"`
+	IsActive           bool       `json:"is_active"`
 }
 
-// Permission constants
+// ErrTokenExpired is returned by validateAPIToken when the token's access
+// window (AccessExpiresAt) has passed. Callers use this, rather than the
+// generic "not found" error, to tell a client it should hit
+// POST /api/tokens/refresh instead of re-authenticating from scratch.
+var ErrTokenExpired = fmt.Errorf("access token expired")
+
+// Permission constants. These are kept as the vocabulary accepted by
+// clients that predate the scope grammar; legacyScopeEquivalents below
+// translates each one to its equivalent scope so tokens created before the
+// scope rewrite keep working unchanged.
 const (
 	PermissionReadInsights = "read:insights"
 	PermissionReadHealth   = "read:health"
@@ -31,6 +47,78 @@ const (
 	PermissionAdmin        = "admin:all"
 )
 
+// legacyScopeEquivalents maps each legacy flat permission string to the
+// scope it now means. admin:all granted every permission, so it maps to the
+// all-wildcard scope rather than to admin:*:*.
+var legacyScopeEquivalents = map[string]string{
+	PermissionReadInsights: "insights:*:read",
+	PermissionReadHealth:   "health:*:read",
+	PermissionManageTokens: "tokens:*:manage",
+	PermissionAdmin:        "*:*:*",
+}
+
+// routeScopes maps a route's path prefix to the scope required to access
+// it. Filled via RegisterRouteScope, starting with the built-in entries
+// registered in init below; hasPermission consults it to decide what a
+// token needs to grant.
+var routeScopes []routeScope
+
+type routeScope struct {
+	prefix   string
+	required scope.Scope
+}
+
+func init() {
+	RegisterRouteScope("/api/insights", mustParseScope("insights:*:read"))
+	RegisterRouteScope("/api/health", mustParseScope("health:*:read"))
+	RegisterRouteScope("/api/tokens", mustParseScope("tokens:*:manage"))
+}
+
+func mustParseScope(s string) scope.Scope {
+	sc, err := scope.Parse(s)
+	if err != nil {
+		panic(fmt.Sprintf("auth: invalid built-in scope %q: %v", s, err))
+	}
+	return sc
+}
+
+// RegisterRouteScope associates a path prefix with the scope required to
+// access it. Called at mux setup for each route table entry; longer
+// prefixes should be registered before shorter overlapping ones, since the
+// first match wins.
+func RegisterRouteScope(prefix string, required scope.Scope) {
+	routeScopes = append(routeScopes, routeScope{prefix: prefix, required: required})
+}
+
+// requiredScopeForPath returns the scope a token must satisfy to access
+// path, and whether one is registered at all.
+func requiredScopeForPath(path string) (scope.Scope, bool) {
+	for _, rs := range routeScopes {
+		if strings.HasPrefix(path, rs.prefix) {
+			return rs.required, true
+		}
+	}
+	return scope.Scope{}, false
+}
+
+// parsePermissions expands legacy flat permission strings to their scope
+// equivalent and parses everything else as a scope directly, so a token's
+// Permissions column can freely mix old and new-style entries.
+func parsePermissions(raw []string) ([]scope.Scope, error) {
+	scopes := make([]scope.Scope, 0, len(raw))
+	for _, p := range raw {
+		if equivalent, ok := legacyScopeEquivalents[p]; ok {
+			p = equivalent
+		}
+		sc, err := scope.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("permission %q: %w", p, err)
+		}
+		scopes = append(scopes, sc)
+	}
+	return scopes, nil
+}
+
 // APITokenMiddleware validates API tokens for protected endpoints
 func (s *AnalyticsServer) APITokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +132,9 @@ func (s *AnalyticsServer) APITokenMiddleware(next http.Handler) http.Handler {
 		apiToken, err := s.validateAPIToken(token)
 		if err != nil {
 			s.logAuthFailure(r, fmt.Sprintf("Invalid token: %v", err))
+			if err == ErrTokenExpired {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token expired"`)
+			}
 			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
 			return
 		}
@@ -55,6 +146,10 @@ func (s *AnalyticsServer) APITokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if !s.checkRateLimit(w, apiToken) {
+			return
+		}
+
 		// Update token last used timestamp
 		go s.updateTokenLastUsed(apiToken.ID)
 
@@ -117,14 +212,16 @@ func (s *AnalyticsServer) validateAPIToken(token string) (*APIToken, error) {
 
 	tokenHash := hashToken(token)
 
-	// Simplified query to avoid JSON parsing issues for now
 	var apiToken APIToken
+	var permissionsJSON string
+	var rateLimitPerMinute, dailyQuota sql.NullInt64
 	err := s.db.QueryRow(`
-		SELECT id, token_hash, name, is_active
-		FROM api_tokens 
+		SELECT id, token_hash, name, permissions, access_expires_at, rate_limit_per_minute, daily_quota, is_active
+		FROM api_tokens
 		WHERE token_hash = ? AND is_active = true
 	`, tokenHash).Scan(
-		&apiToken.ID, &apiToken.TokenHash, &apiToken.Name, &apiToken.IsActive,
+		&apiToken.ID, &apiToken.TokenHash, &apiToken.Name, &permissionsJSON, &apiToken.AccessExpiresAt,
+		&rateLimitPerMinute, &dailyQuota, &apiToken.IsActive,
 	)
 
 	if err != nil {
@@ -134,53 +231,76 @@ func (s *AnalyticsServer) validateAPIToken(token string) (*APIToken, error) {
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	// Set default permissions for now
-	apiToken.Permissions = []string{"read:insights", "read:health"}
+	if permissionsJSON != "" {
+		if err := json.Unmarshal([]byte(permissionsJSON), &apiToken.Permissions); err != nil {
+			return nil, fmt.Errorf("malformed permissions: %v", err)
+		}
+	}
+
+	if apiToken.AccessExpiresAt != nil && time.Now().After(*apiToken.AccessExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	apiToken.RateLimitPerMinute = int(rateLimitPerMinute.Int64)
+	apiToken.DailyQuota = int(dailyQuota.Int64)
 
 	return &apiToken, nil
 }
 
-// hasPermission checks if token has required permissions for endpoint
+// hasPermission checks whether a token's granted scopes satisfy the scope
+// required by the route table for path.
 func (s *AnalyticsServer) hasPermission(token *APIToken, path string) bool {
-	// Admin tokens have all permissions
-	for _, perm := range token.Permissions {
-		if perm == PermissionAdmin {
-			return true
-		}
-	}
-
-	// Check specific permissions based on path
-	switch {
-	case strings.HasPrefix(path, "/api/insights"):
-		return s.hasTokenPermission(token, PermissionReadInsights)
-	case strings.HasPrefix(path, "/api/health"):
-		return s.hasTokenPermission(token, PermissionReadHealth)
-	default:
+	required, ok := requiredScopeForPath(path)
+	if !ok {
 		return false
 	}
+	return s.tokenMatcher(token).Allows(required)
 }
 
 // hasAdminPermission checks if token has admin permissions
 func (s *AnalyticsServer) hasAdminPermission(token *APIToken) bool {
-	return s.hasTokenPermission(token, PermissionAdmin) ||
-		s.hasTokenPermission(token, PermissionManageTokens)
+	matcher := s.tokenMatcher(token)
+	return matcher.Allows(mustParseScope("admin:*:*")) || matcher.Allows(mustParseScope("tokens:*:manage"))
 }
 
-// hasTokenPermission checks if token has specific permission
+// hasTokenPermission checks if token has the given legacy-or-scope
+// permission string.
 func (s *AnalyticsServer) hasTokenPermission(token *APIToken, permission string) bool {
-	for _, perm := range token.Permissions {
-		if perm == permission || perm == PermissionAdmin {
-			return true
+	if equivalent, ok := legacyScopeEquivalents[permission]; ok {
+		permission = equivalent
+	}
+	required, err := scope.Parse(permission)
+	if err != nil {
+		log.Printf("hasTokenPermission: %v", err)
+		return false
+	}
+	return s.tokenMatcher(token).Allows(required)
+}
+
+// tokenMatcher parses a token's stored permissions into a ScopeMatcher.
+// Malformed entries are dropped rather than failing the whole request, and
+// logged so an operator notices a bad grant.
+func (s *AnalyticsServer) tokenMatcher(token *APIToken) scope.ScopeMatcher {
+	granted := make([]scope.Scope, 0, len(token.Permissions))
+	for _, p := range token.Permissions {
+		if equivalent, ok := legacyScopeEquivalents[p]; ok {
+			p = equivalent
+		}
+		sc, err := scope.Parse(p)
+		if err != nil {
+			log.Printf("tokenMatcher: dropping invalid permission %q on token %s: %v", p, token.ID, err)
+			continue
 		}
+		granted = append(granted, sc)
 	}
-	return false
+	return scope.NewMatcher(granted)
 }
 
 // updateTokenLastUsed updates the last_used timestamp for a token
 func (s *AnalyticsServer) updateTokenLastUsed(tokenID string) {
 	_, err := s.db.Exec(`
-		UPDATE api_tokens 
-		SET last_used = CURRENT_TIMESTAMP 
+		UPDATE api_tokens
+		SET last_used = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`, tokenID)
 
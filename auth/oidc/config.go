@@ -0,0 +1,180 @@
+// Package oidc implements OIDC/OAuth2 single sign-on for the analytics dashboard.
+// It replaces hardcoded HTTP Basic Auth with a PKCE-protected authorization code
+// flow against a configurable issuer (Google, Auth0, a self-hosted dex, etc),
+// validates the returned ID token against the issuer's published JWKS, and
+// establishes a server-signed session cookie for subsequent requests.
+package oidc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultDashboardScopes is granted to an identity that passed the
+// allowlist but has no entry in GroupScopes/EmailScopes - read-only
+// dashboard access, not admin. The values are the same literal strings as
+// the Permission* constants in the main package (e.g. "read:insights");
+// this package doesn't import main to avoid a cycle, so it just treats them
+// as opaque strings an operator configures to match.
+var defaultDashboardScopes = []string{"read:insights", "read:health"}
+
+// Config holds the settings needed to talk to an OIDC provider and to decide
+// which authenticated users are allowed in, and what they're allowed to do
+// once they are.
+type Config struct {
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	AllowedEmails []string
+	AllowedGroups []string
+
+	// GroupScopes and EmailScopes map a group name or email to the
+	// Permission* strings it grants, letting OIDC_ALLOWED_EMAILS/
+	// OIDC_ALLOWED_GROUPS double as a scope assignment instead of a purely
+	// binary admit/deny gate. An identity matching neither map falls back
+	// to defaultDashboardScopes.
+	GroupScopes map[string][]string
+	EmailScopes map[string][]string
+
+	// SessionSecret signs the server-side session cookie (HS256). If empty,
+	// ConfigFromEnv generates an ephemeral secret, which invalidates sessions
+	// on every restart - fine for single-instance deployments, not for a
+	// multi-replica one (set OIDC_SESSION_SECRET explicitly there).
+	SessionSecret []byte
+}
+
+// ConfigFromEnv builds a Config from the OIDC_* environment variables. ok is
+// false when OIDC_ISSUER_URL is unset, meaning SSO is disabled and the caller
+// should fall back to DASHBOARD_USERNAME/DASHBOARD_PASSWORD.
+func ConfigFromEnv() (cfg Config, ok bool) {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return Config{}, false
+	}
+
+	cfg = Config{
+		IssuerURL:     strings.TrimSuffix(issuer, "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		AllowedEmails: splitAndTrim(os.Getenv("OIDC_ALLOWED_EMAILS")),
+		AllowedGroups: splitAndTrim(os.Getenv("OIDC_ALLOWED_GROUPS")),
+		GroupScopes:   parseScopeMap(os.Getenv("OIDC_GROUP_SCOPES")),
+		EmailScopes:   parseScopeMap(os.Getenv("OIDC_EMAIL_SCOPES")),
+	}
+
+	if secret := os.Getenv("OIDC_SESSION_SECRET"); secret != "" {
+		cfg.SessionSecret = []byte(secret)
+	} else {
+		cfg.SessionSecret = make([]byte, 32)
+		if _, err := rand.Read(cfg.SessionSecret); err != nil {
+			panic(fmt.Sprintf("oidc: failed to generate session secret: %v", err))
+		}
+	}
+
+	return cfg, true
+}
+
+// Validate checks that the fields required to start the authorization code
+// flow are present.
+func (c Config) Validate() error {
+	if c.ClientID == "" {
+		return fmt.Errorf("OIDC_CLIENT_ID is required")
+	}
+	if c.RedirectURL == "" {
+		return fmt.Errorf("OIDC_REDIRECT_URL is required")
+	}
+	return nil
+}
+
+// parseScopeMap parses "key1:scope1,scope2;key2:scope3" (as used by
+// OIDC_GROUP_SCOPES and OIDC_EMAIL_SCOPES) into a map from key - an email or
+// a group name - to its list of granted Permission* strings.
+func parseScopeMap(s string) map[string][]string {
+	if s == "" {
+		return nil
+	}
+	out := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, scopesStr, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		out[strings.TrimSpace(key)] = splitAndTrim(scopesStr)
+	}
+	return out
+}
+
+func splitAndTrim(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// EmailAllowed reports whether the given email or group membership is
+// permitted to sign in. An empty allowlist means "allow any authenticated
+// user" (the operator is relying on the issuer itself to gate membership).
+func (c Config) EmailAllowed(email string, groups []string) bool {
+	if len(c.AllowedEmails) == 0 && len(c.AllowedGroups) == 0 {
+		return true
+	}
+
+	for _, allowed := range c.AllowedEmails {
+		if strings.EqualFold(allowed, email) {
+			return true
+		}
+	}
+
+	for _, g := range groups {
+		for _, allowed := range c.AllowedGroups {
+			if allowed == g {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ScopesFor derives the Permission* strings an already-allowed identity
+// should be granted: every EmailScopes entry for email, plus every
+// GroupScopes entry matching one of groups. An identity with no entry in
+// either map gets defaultDashboardScopes.
+func (c Config) ScopesFor(email string, groups []string) []string {
+	seen := make(map[string]bool)
+	var scopes []string
+	add := func(perms []string) {
+		for _, p := range perms {
+			if !seen[p] {
+				seen[p] = true
+				scopes = append(scopes, p)
+			}
+		}
+	}
+
+	add(c.EmailScopes[email])
+	for _, g := range groups {
+		add(c.GroupScopes[g])
+	}
+
+	if len(scopes) == 0 {
+		return append([]string(nil), defaultDashboardScopes...)
+	}
+	return scopes
+}
@@ -0,0 +1,202 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LoginHandler redirects the browser to the issuer's authorization endpoint,
+// stashing the PKCE verifier, nonce and post-login destination in a signed
+// state cookie.
+func (p *Provider) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	verifier, challenge, err := newPKCE()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	state, err := randomString(16)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+
+	token, err := signValue(p.cfg.SessionSecret, stateClaims{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ReturnTo:     returnTo,
+		ExpiresAt:    time.Now().Add(stateTTL).Unix(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    token,
+		Path:     "/auth",
+		Expires:  time.Now().Add(stateTTL),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {"openid email profile groups"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	http.Redirect(w, r, p.discovery.AuthorizationEndpoint+"?"+authURL.Encode(), http.StatusFound)
+}
+
+// CallbackHandler exchanges the authorization code for tokens, validates the
+// ID token and, if the user is allowed in, mints a session cookie.
+func (p *Provider) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil {
+		http.Error(w, "Missing login state - please try again", http.StatusBadRequest)
+		return
+	}
+	clearCookie(w, stateCookieName, "/auth")
+
+	var state stateClaims
+	if err := parseValue(p.cfg.SessionSecret, cookie.Value, &state); err != nil {
+		http.Error(w, "Invalid login state - please try again", http.StatusBadRequest)
+		return
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		http.Error(w, "Login expired - please try again", http.StatusBadRequest)
+		return
+	}
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s", errParam), http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("state") != state.State {
+		http.Error(w, "Login state mismatch - please try again", http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	tokenResp, err := p.exchangeCode(code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("oidc: code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := p.verifyIDToken(tokenResp.IDToken, state.Nonce)
+	if err != nil {
+		log.Printf("oidc: id_token verification failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if !claims.Verified && claims.Email != "" {
+		log.Printf("oidc: login for %s rejected: email not verified by issuer", claims.Email)
+		http.Error(w, "Forbidden: email not verified", http.StatusForbidden)
+		return
+	}
+	if !p.cfg.EmailAllowed(claims.Email, claims.Groups) {
+		log.Printf("oidc: login for %s rejected: not in allowlist", claims.Email)
+		http.Error(w, "Forbidden: not authorized for this dashboard", http.StatusForbidden)
+		return
+	}
+
+	permissions := p.cfg.ScopesFor(claims.Email, claims.Groups)
+	if err := p.setSessionCookie(w, claims.Email, claims.Groups, permissions); err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+}
+
+// LogoutHandler clears the session cookie.
+func (p *Provider) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, SessionCookieName, "/")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "logged_out"})
+}
+
+// JWKSHandler serves this provider's JSON Web Key Set. Session cookies are
+// only ever signed with HMAC-SHA256 (see signValue); there is no RS256
+// signing mode, so there is no public key to publish and the handler always
+// returns an empty key set.
+func (p *Provider) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{}})
+}
+
+type tokenResponse struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (p *Provider) exchangeCode(code, codeVerifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	resp, err := p.httpClient.PostForm(p.discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+	if tr.IDToken == "" {
+		return nil, fmt.Errorf("token response missing id_token")
+	}
+
+	return &tr, nil
+}
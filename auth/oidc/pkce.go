@@ -0,0 +1,34 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// sha256Sum is a tiny convenience wrapper so callers don't have to juggle
+// the [32]byte array returned by sha256.Sum256.
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+// randomString returns a URL-safe random string with n bytes of entropy,
+// used for PKCE code verifiers, state and nonce values.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newPKCE generates a code verifier and its S256 code challenge per RFC 7636.
+func newPKCE() (verifier, challenge string, err error) {
+	verifier, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	challenge = base64.RawURLEncoding.EncodeToString(sha256Sum(verifier))
+	return verifier, challenge, nil
+}
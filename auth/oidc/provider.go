@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how long a cached JWKS is trusted before we
+// re-fetch it from the issuer, so a key rotation on their end is picked up
+// without requiring a restart on ours.
+const jwksRefreshInterval = 1 * time.Hour
+
+// discoveryDoc is the subset of the issuer's .well-known/openid-configuration
+// document that the login/callback flow needs.
+type discoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider wraps a discovered OIDC issuer: its endpoints and a cache of its
+// signing keys, plus the client config used to build authorization requests.
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+
+	discovery discoveryDoc
+
+	mu     sync.RWMutex
+	keys   map[string]*jwk
+	keysAt time.Time
+}
+
+// NewProvider fetches the issuer's discovery document and primes the JWKS
+// cache. The returned Provider is safe for concurrent use.
+func NewProvider(cfg Config) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if err := p.fetchDiscovery(); err != nil {
+		return nil, err
+	}
+
+	// A transient JWKS blip at startup shouldn't keep the provider from
+	// coming up: keyForKID already retries the fetch lazily whenever the
+	// cache is stale or missing a kid (p.keysAt stays its zero value here,
+	// which keyForKID's staleness check treats as "needs a refresh"), so an
+	// empty cache just means the first verification after startup pays the
+	// fetch cost instead of construction failing outright.
+	if err := p.refreshKeys(); err != nil {
+		log.Printf("oidc: initial jwks fetch failed, will retry on first use: %v", err)
+	}
+
+	return p, nil
+}
+
+func (p *Provider) fetchDiscovery() error {
+	url := p.cfg.IssuerURL + "/.well-known/openid-configuration"
+	resp, err := p.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("oidc: fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: discovery document returned status %d", resp.StatusCode)
+	}
+
+	var doc discoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("oidc: decoding discovery document: %w", err)
+	}
+	if doc.Issuer != p.cfg.IssuerURL {
+		return fmt.Errorf("oidc: discovery issuer %q does not match configured issuer %q", doc.Issuer, p.cfg.IssuerURL)
+	}
+
+	p.discovery = doc
+	return nil
+}
+
+// keyForKID returns the JWK matching kid, refreshing the cache first if it
+// is stale or the key is unknown (handles mid-cache key rotation).
+func (p *Provider) keyForKID(kid string) (*jwk, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysAt) > jwksRefreshInterval
+	p.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: no signing key with kid %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) refreshKeys() error {
+	resp, err := p.httpClient.Get(p.discovery.JWKSURI)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: jwks endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("oidc: decoding jwks: %w", err)
+	}
+
+	keys := make(map[string]*jwk, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Kid != "" {
+			keys[k.Kid] = k
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	p.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package scope
+
+// ScopeMatcher evaluates required scopes against a fixed set of granted
+// scopes, expanding "*" resource/target/action wildcards on the granted
+// side.
+type ScopeMatcher struct {
+	granted []Scope
+}
+
+// NewMatcher builds a ScopeMatcher from a token's granted scopes.
+func NewMatcher(granted []Scope) ScopeMatcher {
+	return ScopeMatcher{granted: granted}
+}
+
+// Allows reports whether the granted scopes satisfy every action of the
+// required scope for its resource and target.
+func (m ScopeMatcher) Allows(required Scope) bool {
+	for _, action := range required.Actions {
+		if !m.allowsAction(required.Resource, required.Target, action) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m ScopeMatcher) allowsAction(resource, target, action string) bool {
+	for _, g := range m.granted {
+		if g.Resource != Wildcard && g.Resource != resource {
+			continue
+		}
+		// A required target of "*" means "any target on this resource will
+		// do" (e.g. a route that doesn't care which insight was requested),
+		// so it's satisfied by a grant scoped to one specific target, not
+		// only by a grant that is itself "*".
+		if target != Wildcard && g.Target != Wildcard && g.Target != target {
+			continue
+		}
+		if g.hasAction(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether any granted scope matches resource:target:action
+// exactly (after wildcard expansion), ignoring the rest of a compound
+// required scope. Useful for simple admin-style checks.
+func (m ScopeMatcher) Has(resource, target, action string) bool {
+	return m.allowsAction(resource, target, action)
+}
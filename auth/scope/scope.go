@@ -0,0 +1,81 @@
+// Package scope implements the fine-grained permission grammar used by
+// analytics API tokens: "resource:target:actions", e.g. "insights:usage:read"
+// or "insights:*:read,export". A "*" in the resource, target or action
+// position is a wildcard matching any value, following the scope grammar used by docker's
+// registry token server.
+package scope
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Wildcard matches any resource, target or action.
+const Wildcard = "*"
+
+// Scope is a single granted or required capability.
+type Scope struct {
+	Resource string
+	Target   string
+	Actions  []string
+}
+
+// Parse parses a scope string of the form "resource:target:action,action".
+// Resource and target are single tokens (optionally "*"); actions are a
+// comma-separated list (optionally a single "*").
+func Parse(s string) (Scope, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Scope{}, fmt.Errorf("scope: %q must have the form resource:target:actions", s)
+	}
+
+	resource, target, actionList := parts[0], parts[1], parts[2]
+	if resource == "" || target == "" || actionList == "" {
+		return Scope{}, fmt.Errorf("scope: %q has an empty component", s)
+	}
+
+	actions := strings.Split(actionList, ",")
+	for i, a := range actions {
+		actions[i] = strings.TrimSpace(a)
+		if actions[i] == "" {
+			return Scope{}, fmt.Errorf("scope: %q has an empty action", s)
+		}
+	}
+
+	return Scope{Resource: resource, Target: target, Actions: actions}, nil
+}
+
+// ParseAll parses a list of scope strings, one scope per element (as stored
+// in APIToken.Permissions), and fails on the first malformed one. Only the
+// actions are trimmed of surrounding spaces; resource and target are taken
+// as written.
+func ParseAll(scopes []string) ([]Scope, error) {
+	out := make([]Scope, 0, len(scopes))
+	for _, s := range scopes {
+		sc, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, nil
+}
+
+// String renders the scope back to its canonical "resource:target:actions" form.
+func (s Scope) String() string {
+	actions := append([]string(nil), s.Actions...)
+	sort.Strings(actions)
+	return fmt.Sprintf("%s:%s:%s", s.Resource, s.Target, strings.Join(actions, ","))
+}
+
+// hasAction reports whether the scope grants the given action, honoring a
+// wildcard action entry.
+func (s Scope) hasAction(action string) bool {
+	for _, a := range s.Actions {
+		if a == Wildcard || a == action {
+			return true
+		}
+	}
+	return false
+}
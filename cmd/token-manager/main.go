@@ -10,23 +10,32 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	_ "github.com/marcboeker/go-duckdb"
+
+	"github.com/thegreenfieldoverride/liberation-analytics/auth/scope"
 )
 
 func main() {
 	var (
-		command     = flag.String("command", "", "Command: create, hash-password")
-		name        = flag.String("name", "", "Token name")
-		permissions = flag.String("permissions", "", "Comma-separated permissions")
+		command     = flag.String("command", "", "Command: create, create-invite, hash-password")
+		name        = flag.String("name", "", "Token name (create) or invite label (create-invite)")
+		permissions = flag.String("permissions", "", "Semicolon-separated scopes, e.g. 'insights:*:read;tokens:*:manage'")
 		password    = flag.String("password", "", "Password to hash")
 		expires     = flag.String("expires", "", "Expiration (e.g., 30d, 1y)")
+		usesAllowed = flag.Int("uses-allowed", 0, "create-invite: number of redemptions allowed, 0 for unlimited")
+		length      = flag.Int("length", 0, "create-invite: entropy bytes for the invite token, 0 for default")
+		rateLimit   = flag.Int("rate-limit-per-minute", 0, "create: per-minute request limit, 0 for unlimited")
+		dailyQuota  = flag.Int("daily-quota", 0, "create: daily request quota, 0 for unlimited")
 	)
 	flag.Parse()
 
 	switch *command {
 	case "create":
-		createToken(*name, *permissions, *expires)
+		createToken(*name, *permissions, *expires, *rateLimit, *dailyQuota)
+	case "create-invite":
+		createInvite(*name, *permissions, *expires, *usesAllowed, *length)
 	case "hash-password":
 		if *password == "" {
 			log.Fatal("Password is required for hash-password command")
@@ -34,13 +43,14 @@ func main() {
 		hashPassword(*password)
 	default:
 		fmt.Println("Usage:")
-		fmt.Println("  go run cmd/token-manager/main.go -command=create -name='Frontend API' -permissions='read:insights,read:health'")
+		fmt.Println("  go run cmd/token-manager/main.go -command=create -name='Frontend API' -permissions='insights:*:read;health:*:read'")
+		fmt.Println("  go run cmd/token-manager/main.go -command=create-invite -name='Onboarding' -permissions='insights:*:read' -uses-allowed=5 -expires=30d")
 		fmt.Println("  go run cmd/token-manager/main.go -command=hash-password -password='your_password'")
 		os.Exit(1)
 	}
 }
 
-func createToken(name, permissions, expires string) {
+func createToken(name, permissions, expires string, rateLimitPerMinute, dailyQuota int) {
 	if name == "" {
 		log.Fatal("Token name is required")
 	}
@@ -63,13 +73,24 @@ func createToken(name, permissions, expires string) {
 	}
 	defer db.Close()
 
+	permissionsJSON, err := formatPermissions(permissions)
+	if err != nil {
+		log.Fatal("Invalid permissions:", err)
+	}
 	tokenHash := hashToken(token)
-	permissionsJSON := formatPermissions(permissions)
+
+	var rateLimitArg, dailyQuotaArg interface{}
+	if rateLimitPerMinute > 0 {
+		rateLimitArg = rateLimitPerMinute
+	}
+	if dailyQuota > 0 {
+		dailyQuotaArg = dailyQuota
+	}
 
 	_, err = db.Exec(`
-		INSERT INTO api_tokens (token_hash, name, permissions, expires_at, is_active) 
-		VALUES (?, ?, ?, NULL, true)
-	`, tokenHash, name, permissionsJSON)
+		INSERT INTO api_tokens (token_hash, name, permissions, expires_at, rate_limit_per_minute, daily_quota, is_active)
+		VALUES (?, ?, ?, NULL, ?, ?, true)
+	`, tokenHash, name, permissionsJSON, rateLimitArg, dailyQuotaArg)
 
 	if err != nil {
 		log.Fatal("Failed to insert token into database:", err)
@@ -82,6 +103,12 @@ func createToken(name, permissions, expires string) {
 	if expires != "" {
 		fmt.Printf("Expires: %s\n", expires)
 	}
+	if rateLimitPerMinute > 0 {
+		fmt.Printf("Rate limit: %d requests/minute\n", rateLimitPerMinute)
+	}
+	if dailyQuota > 0 {
+		fmt.Printf("Daily quota: %d requests/day\n", dailyQuota)
+	}
 	fmt.Printf("\n✅ Token has been inserted into the database and is ready to use!\n")
 	fmt.Printf("\nTo use this token, add it to your API requests:\n")
 	fmt.Printf("curl -H 'X-API-Key: %s' http://localhost:8082/api/health\n", token)
@@ -89,6 +116,80 @@ func createToken(name, permissions, expires string) {
 	fmt.Printf("\nStore this token securely - it cannot be retrieved again!\n")
 }
 
+func createInvite(label, permissions, expires string, usesAllowed, length int) {
+	if label == "" {
+		log.Fatal("Invite label is required")
+	}
+	if permissions == "" {
+		log.Fatal("Permissions are required")
+	}
+
+	scopeTemplateJSON, err := formatPermissions(permissions)
+	if err != nil {
+		log.Fatal("Invalid permissions:", err)
+	}
+
+	if length <= 0 {
+		length = 24
+	}
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatal("Failed to generate random token:", err)
+	}
+	token := "invite_" + hex.EncodeToString(bytes)
+
+	db, err := sql.Open("duckdb", "./analytics.db")
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	var usesAllowedArg interface{}
+	if usesAllowed > 0 {
+		usesAllowedArg = usesAllowed
+	}
+
+	var expiresAtArg interface{}
+	if expires != "" {
+		var n int
+		var unit string
+		fmt.Sscanf(expires, "%d%s", &n, &unit)
+		perUnit := map[string]time.Duration{"d": 24 * time.Hour, "y": 365 * 24 * time.Hour}[unit]
+		if perUnit == 0 {
+			d, err := time.ParseDuration(expires)
+			if err != nil {
+				log.Fatal("Invalid expiration (e.g., 30d, 1y):", expires)
+			}
+			n, perUnit = 1, d
+		}
+		expiresAtArg = time.Now().Add(time.Duration(n) * perUnit)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO invite_tokens (token_hash, label, scope_template, uses_allowed, uses_remaining, expires_at, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, true)
+	`, hashToken(token), label, scopeTemplateJSON, usesAllowedArg, usesAllowedArg, expiresAtArg)
+
+	if err != nil {
+		log.Fatal("Failed to insert invite token into database:", err)
+	}
+
+	fmt.Printf("Generated Invite Token:\n")
+	fmt.Printf("Token: %s\n", token)
+	fmt.Printf("Label: %s\n", label)
+	fmt.Printf("Permissions: %s\n", permissions)
+	if usesAllowed > 0 {
+		fmt.Printf("Uses allowed: %d\n", usesAllowed)
+	} else {
+		fmt.Printf("Uses allowed: unlimited\n")
+	}
+	if expires != "" {
+		fmt.Printf("Expires: %s\n", expires)
+	}
+	fmt.Printf("\nTo redeem this invite:\n")
+	fmt.Printf("curl -X POST -d '{\"invite_token\":\"%s\",\"name\":\"my-service\"}' http://localhost:8082/api/tokens/redeem\n", token)
+}
+
 func hashPassword(password string) {
 	// For now, just print the password for basic auth
 	// In production, you'd want to use bcrypt
@@ -106,13 +207,54 @@ func hashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func formatPermissions(permissions string) string {
-	perms := strings.Split(permissions, ",")
-	var jsonPerms []string
+// formatPermissions validates each semicolon-separated scope (or legacy
+// permission constant) and renders the list as a JSON array for storage in
+// the permissions column.
+func formatPermissions(permissions string) (string, error) {
+	perms := strings.Split(permissions, ";")
+	jsonPerms := make([]string, 0, len(perms))
 	for _, perm := range perms {
-		jsonPerms = append(jsonPerms, fmt.Sprintf(`"%s"`, strings.TrimSpace(perm)))
+		perm = strings.TrimSpace(perm)
+		if !isLegacyPermission(perm) {
+			if err := rejectLegacyCommaList(perm); err != nil {
+				return "", err
+			}
+			if _, err := scope.Parse(perm); err != nil {
+				return "", err
+			}
+		}
+		jsonPerms = append(jsonPerms, fmt.Sprintf(`"%s"`, perm))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(jsonPerms, ",")), nil
+}
+
+// rejectLegacyCommaList rejects a scope with a comma in its resource or
+// target position. -permissions used to be comma-joined ("a,b,c"); it is now
+// semicolon-joined, with a comma only meaningful inside a scope's action
+// list (the part after the second colon). Without this check, an operator
+// script still passing the old comma delimiter - e.g.
+// "read:insights,read:health" - parses as the single bogus scope
+// resource=read, target="insights,read", action=health and gets stored
+// without error, a silent privilege mis-grant.
+func rejectLegacyCommaList(perm string) error {
+	parts := strings.SplitN(perm, ":", 3)
+	for _, part := range parts[:len(parts)-1] {
+		if strings.Contains(part, ",") {
+			return fmt.Errorf("permission %q looks like a comma-separated list; use ';' to separate multiple permissions, not ','", perm)
+		}
+	}
+	return nil
+}
+
+// isLegacyPermission reports whether perm is one of the pre-scope flat
+// permission constants, kept accepted here for operators' existing scripts.
+func isLegacyPermission(perm string) bool {
+	switch perm {
+	case "read:insights", "read:health", "manage:tokens", "admin:all":
+		return true
+	default:
+		return false
 	}
-	return fmt.Sprintf("[%s]", strings.Join(jsonPerms, ","))
 }
 
 func formatExpiration(expires string) string {
@@ -4,21 +4,88 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/thegreenfieldoverride/liberation-analytics/auth/oidc"
 )
 
-// DashboardAuthMiddleware provides basic HTTP authentication for the dashboard
-func DashboardAuthMiddleware(next http.Handler) http.Handler {
+// dashboardOIDC is the process-wide OIDC provider for dashboard SSO. It is
+// nil when OIDC_ISSUER_URL is unset, or InitDashboardOIDC was never called,
+// in which case DashboardAuthMiddleware falls back to
+// DASHBOARD_USERNAME/DASHBOARD_PASSWORD Basic Auth.
+var dashboardOIDC *oidc.Provider
+
+// InitDashboardOIDC wires up OIDC dashboard SSO from OIDC_ISSUER_URL and
+// friends, if configured. It is a no-op, returning nil, when OIDC isn't
+// configured. Call it once from server setup, after flags/logging are in
+// place and before serving any requests - it does the issuer discovery
+// fetch, so it must not run inside a package init(), where a transient
+// issuer blip would take the whole process down via log.Fatalf before main
+// even starts, and where there's no way to exercise it from a test.
+func InitDashboardOIDC() error {
+	cfg, ok := oidc.ConfigFromEnv()
+	if !ok {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(cfg)
+	if err != nil {
+		return fmt.Errorf("oidc: failed to initialize provider: %w", err)
+	}
+	dashboardOIDC = provider
+	log.Printf("Dashboard SSO enabled via OIDC issuer %s", cfg.IssuerURL)
+	return nil
+}
+
+// RegisterOIDCRoutes wires up /auth/login, /auth/callback, /auth/logout and
+// /auth/jwks when SSO is enabled. It is a no-op otherwise.
+func RegisterOIDCRoutes(router *mux.Router) {
+	if dashboardOIDC == nil {
+		return
+	}
+	router.HandleFunc("/auth/login", dashboardOIDC.LoginHandler)
+	router.HandleFunc("/auth/callback", dashboardOIDC.CallbackHandler)
+	router.HandleFunc("/auth/logout", dashboardOIDC.LogoutHandler)
+	router.HandleFunc("/auth/jwks", dashboardOIDC.JWKSHandler)
+}
+
+// DashboardAuthMiddleware authenticates dashboard requests. It accepts, in
+// order: an OIDC session cookie (browser dashboard users once SSO is
+// configured), a validated API token (programmatic access, same as the
+// /api/* routes), and finally falls back to legacy Basic Auth against
+// DASHBOARD_USERNAME/DASHBOARD_PASSWORD for deployments that haven't
+// migrated to SSO yet. It is a method, rather than a free function, because
+// the API token fallback needs s.db to actually validate the token.
+//
+// An OIDC session carries Permission* scopes derived from OIDC_GROUP_SCOPES/
+// OIDC_EMAIL_SCOPES (see Config.ScopesFor), but this middleware is a single
+// all-or-nothing gate on the dashboard as a whole - it doesn't yet route
+// individual dashboard pages or actions through those scopes the way
+// APITokenMiddleware does for /api/*. Narrower enforcement needs the
+// dashboard's own routes split by required permission first.
+func (s *AnalyticsServer) DashboardAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			requestAuth(w)
-			return
+		if dashboardOIDC != nil {
+			if _, _, _, ok := dashboardOIDC.Authenticate(r); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
 		}
 
-		if !validateDashboardCredentials(username, password) {
+		if token := extractToken(r); token != "" {
+			if apiToken, err := s.validateAPIToken(token); err == nil {
+				go s.updateTokenLastUsed(apiToken.ID)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok || !validateDashboardCredentials(username, password) {
 			requestAuth(w)
 			return
 		}
@@ -51,8 +118,17 @@ func validateDashboardCredentials(username, password string) bool {
 	return true
 }
 
-// requestAuth sends a 401 response requesting basic authentication
+// requestAuth sends a 401 response requesting authentication. When SSO is
+// configured it points the browser at the login flow instead of prompting
+// for Basic Auth credentials.
 func requestAuth(w http.ResponseWriter) {
+	if dashboardOIDC != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "Authentication required", "login_url": "/auth/login"}`))
+		return
+	}
+
 	w.Header().Set("WWW-Authenticate", `Basic realm="Liberation Analytics Dashboard"`)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusUnauthorized)
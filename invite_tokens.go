@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultInviteTokenBytes is used when a CreateInviteRequest doesn't specify
+// a custom Length.
+const defaultInviteTokenBytes = 24
+
+// InviteToken represents a self-provisioning invite, distinct from a real
+// APIToken: an admin mints one with a scope template and a budget of uses,
+// and holders redeem it via handleRedeemInvite to get their own APIToken
+// without an admin running the CLI per service account.
+type InviteToken struct {
+	ID            string     `json:"id"`
+	TokenHash     string     `json:"-"`
+	Label         string     `json:"label"`
+	ScopeTemplate []string   `json:"scope_template"`
+	UsesAllowed   *int       `json:"uses_allowed,omitempty"` // nil means unlimited
+	UsesRemaining *int       `json:"uses_remaining,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	IsActive      bool       `json:"is_active"`
+}
+
+// CreateInviteRequest is the body of POST /api/tokens/invites.
+type CreateInviteRequest struct {
+	Label       string   `json:"label"`
+	Permissions []string `json:"permissions"`
+	UsesAllowed *int     `json:"uses_allowed,omitempty"` // omit or null for unlimited
+	ExpiresIn   *string  `json:"expires_in,omitempty"`   // e.g., "30d", null for no expiration
+	Length      *int     `json:"length,omitempty"`       // random bytes of entropy, default 24
+}
+
+// RedeemInviteRequest is the body of POST /api/tokens/redeem.
+type RedeemInviteRequest struct {
+	InviteToken string `json:"invite_token"`
+	Name        string `json:"name"`
+}
+
+// EnsureInviteTokenSchema creates the invite_tokens table if it doesn't
+// already exist. Additive and idempotent, same as EnsureRefreshTokenSchema.
+func EnsureInviteTokenSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS invite_tokens (
+			id              VARCHAR PRIMARY KEY DEFAULT uuid(),
+			token_hash      VARCHAR NOT NULL UNIQUE,
+			label           VARCHAR NOT NULL,
+			scope_template  VARCHAR NOT NULL,
+			uses_allowed    INTEGER,
+			uses_remaining  INTEGER,
+			expires_at      TIMESTAMP,
+			created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			is_active       BOOLEAN DEFAULT true
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("invite token schema migration failed: %w", err)
+	}
+	return nil
+}
+
+// generateInviteToken generates a random invite token of the given number
+// of entropy bytes (defaulting to defaultInviteTokenBytes).
+func generateInviteToken(length int) (string, error) {
+	if length <= 0 {
+		length = defaultInviteTokenBytes
+	}
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "invite_" + hex.EncodeToString(bytes), nil
+}
+
+// handleCreateInvite mints a new invite token from a scope template.
+func (s *AnalyticsServer) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Label == "" {
+		http.Error(w, "Invite label is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Permissions) == 0 {
+		http.Error(w, "At least one permission is required", http.StatusBadRequest)
+		return
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != nil {
+		var err error
+		expiresAt, err = parseExpiresIn(*req.ExpiresIn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	length := 0
+	if req.Length != nil {
+		length = *req.Length
+	}
+	token, err := generateInviteToken(length)
+	if err != nil {
+		http.Error(w, "Failed to generate invite token", http.StatusInternalServerError)
+		return
+	}
+
+	permissionsJSON, err := json.Marshal(req.Permissions)
+	if err != nil {
+		http.Error(w, "Failed to serialize permissions", http.StatusInternalServerError)
+		return
+	}
+
+	var inviteID string
+	err = s.db.QueryRow(`
+		INSERT INTO invite_tokens (token_hash, label, scope_template, uses_allowed, uses_remaining, expires_at, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, true)
+		RETURNING id
+	`, hashToken(token), req.Label, string(permissionsJSON), req.UsesAllowed, req.UsesAllowed, expiresAt).Scan(&inviteID)
+	if err != nil {
+		http.Error(w, "Failed to create invite token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invite_token": token,
+		"invite_id":    inviteID,
+		"label":        req.Label,
+		"permissions":  req.Permissions,
+		"uses_allowed": req.UsesAllowed,
+		"expires_at":   expiresAt,
+	})
+}
+
+// handleListInvites lists all invite tokens (without the actual token values).
+func (s *AnalyticsServer) handleListInvites(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, label, scope_template, uses_allowed, uses_remaining, expires_at, created_at, is_active
+		FROM invite_tokens
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		http.Error(w, "Failed to fetch invite tokens", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var invites []InviteToken
+	for rows.Next() {
+		var invite InviteToken
+		var scopeTemplateJSON string
+
+		if err := rows.Scan(
+			&invite.ID, &invite.Label, &scopeTemplateJSON, &invite.UsesAllowed,
+			&invite.UsesRemaining, &invite.ExpiresAt, &invite.CreatedAt, &invite.IsActive,
+		); err != nil {
+			http.Error(w, "Failed to scan invite token", http.StatusInternalServerError)
+			return
+		}
+
+		if scopeTemplateJSON != "" {
+			if err := json.Unmarshal([]byte(scopeTemplateJSON), &invite.ScopeTemplate); err != nil {
+				http.Error(w, "Failed to parse scope template", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		invites = append(invites, invite)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// handleRevokeInvite deactivates an invite token so it can no longer be redeemed.
+func (s *AnalyticsServer) handleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	inviteID := vars["id"]
+	if inviteID == "" {
+		http.Error(w, "Invite ID is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`UPDATE invite_tokens SET is_active = false WHERE id = ?`, inviteID)
+	if err != nil {
+		http.Error(w, "Failed to revoke invite token", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		http.Error(w, "Failed to verify invite revocation", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected == 0 {
+		http.Error(w, "Invite token not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Invite token revoked successfully",
+	})
+}
+
+// handleRedeemInvite lets a holder of an invite token self-provision a real
+// APIToken inheriting the invite's scope template, atomically decrementing
+// the invite's remaining uses.
+func (s *AnalyticsServer) handleRedeemInvite(w http.ResponseWriter, r *http.Request) {
+	var req RedeemInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.InviteToken == "" {
+		http.Error(w, "invite_token is required", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	inviteHash := hashToken(req.InviteToken)
+
+	var inviteID, scopeTemplateJSON string
+	var usesAllowed, usesRemaining *int
+	var expiresAt *time.Time
+	err := s.db.QueryRow(`
+		SELECT id, scope_template, uses_allowed, uses_remaining, expires_at
+		FROM invite_tokens
+		WHERE token_hash = ? AND is_active = true
+	`, inviteHash).Scan(&inviteID, &scopeTemplateJSON, &usesAllowed, &usesRemaining, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		s.logAuthFailure(r, "Invalid or inactive invite token")
+		http.Error(w, "Unauthorized: invalid invite token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to look up invite token", http.StatusInternalServerError)
+		return
+	}
+
+	if expiresAt != nil && time.Now().After(*expiresAt) {
+		http.Error(w, "Invite token has expired", http.StatusGone)
+		return
+	}
+	if usesAllowed != nil && (usesRemaining == nil || *usesRemaining <= 0) {
+		http.Error(w, "Invite token has no uses remaining", http.StatusGone)
+		return
+	}
+
+	// Atomically claim a use: this UPDATE only affects a row that still has
+	// uses remaining, so concurrent redemptions of the last use can't both
+	// succeed.
+	if usesAllowed != nil {
+		result, err := s.db.Exec(`
+			UPDATE invite_tokens
+			SET uses_remaining = uses_remaining - 1
+			WHERE id = ? AND uses_remaining > 0
+		`, inviteID)
+		if err != nil {
+			http.Error(w, "Failed to redeem invite token", http.StatusInternalServerError)
+			return
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil || rowsAffected == 0 {
+			http.Error(w, "Invite token has no uses remaining", http.StatusGone)
+			return
+		}
+	}
+
+	var permissions []string
+	if err := json.Unmarshal([]byte(scopeTemplateJSON), &permissions); err != nil {
+		http.Error(w, "Invite token has a malformed scope template", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	accessExpiresAt := time.Now().Add(accessTokenTTL)
+	permissionsJSON, _ := json.Marshal(permissions)
+
+	var tokenID string
+	err = s.db.QueryRow(`
+		INSERT INTO api_tokens (token_hash, name, permissions, access_expires_at, refresh_token_hash, is_active)
+		VALUES (?, ?, ?, ?, ?, true)
+		RETURNING id
+	`, hashToken(token), req.Name, string(permissionsJSON), accessExpiresAt, hashToken(refreshToken)).Scan(&tokenID)
+	if err != nil {
+		http.Error(w, "Failed to provision token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CreateTokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		TokenID:      tokenID,
+		APIToken: APIToken{
+			ID:              tokenID,
+			Name:            req.Name,
+			Permissions:     permissions,
+			CreatedAt:       time.Now(),
+			AccessExpiresAt: &accessExpiresAt,
+			IsActive:        true,
+		},
+	})
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// usageFlushInterval controls how often in-memory daily usage counters are
+// persisted to the api_tokens table, so a freshly restarted process can pick
+// up roughly where the last one left off instead of resetting every token's
+// daily quota usage to zero.
+const usageFlushInterval = 1 * time.Minute
+
+// tokenUsage tracks request counts for one token across rolling minute,
+// hour and day windows, entirely in memory. Windows reset lazily: a window
+// whose start has fallen more than its period in the past is treated as
+// empty on the next access rather than proactively cleared.
+type tokenUsage struct {
+	mu sync.Mutex
+
+	minuteStart time.Time
+	minuteCount int
+
+	hourStart time.Time
+	hourCount int
+
+	dayStart time.Time
+	dayCount int
+}
+
+// admitResult reports the outcome of an admit() call: whether the request
+// was allowed, the resulting counts, and the reset time for whichever
+// window is relevant - all read under tokenUsage's lock, so callers never
+// touch tokenUsage fields directly (that data race is what admit()/snapshot()
+// exist to prevent).
+type admitResult struct {
+	admitted       bool
+	minuteExceeded bool
+	dailyExceeded  bool
+	minuteCount    int
+	minuteReset    time.Time
+	dayCount       int
+	dayReset       time.Time
+}
+
+// admit rolls the usage windows forward if they've expired, then - only if
+// the request would stay within both minuteLimit and dailyLimit (a limit of
+// 0 means unlimited) - increments the counters and admits it. A rejected
+// request does not consume any of the token's budget, so a throttled client
+// doesn't have its daily quota burned by requests it never got credit for.
+func (u *tokenUsage) admit(now time.Time, minuteLimit, dailyLimit int) admitResult {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if now.Sub(u.minuteStart) >= time.Minute {
+		u.minuteStart = now
+		u.minuteCount = 0
+	}
+	if now.Sub(u.hourStart) >= time.Hour {
+		u.hourStart = now
+		u.hourCount = 0
+	}
+	if now.Sub(u.dayStart) >= 24*time.Hour {
+		u.dayStart = now
+		u.dayCount = 0
+	}
+
+	result := admitResult{
+		minuteCount: u.minuteCount,
+		minuteReset: u.minuteStart.Add(time.Minute),
+		dayCount:    u.dayCount,
+		dayReset:    u.dayStart.Add(24 * time.Hour),
+	}
+
+	if minuteLimit > 0 && u.minuteCount+1 > minuteLimit {
+		result.minuteExceeded = true
+		return result
+	}
+	if dailyLimit > 0 && u.dayCount+1 > dailyLimit {
+		result.dailyExceeded = true
+		return result
+	}
+
+	u.minuteCount++
+	u.hourCount++
+	u.dayCount++
+
+	result.admitted = true
+	result.minuteCount = u.minuteCount
+	result.dayCount = u.dayCount
+	return result
+}
+
+// snapshot returns the current counts without admitting or rejecting a
+// request, for reporting (GET /api/tokens/{id}/usage).
+func (u *tokenUsage) snapshot(now time.Time) (minuteCount, hourCount, dayCount int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	minuteCount = u.minuteCount
+	if now.Sub(u.minuteStart) >= time.Minute {
+		minuteCount = 0
+	}
+	hourCount = u.hourCount
+	if now.Sub(u.hourStart) >= time.Hour {
+		hourCount = 0
+	}
+	dayCount = u.dayCount
+	if now.Sub(u.dayStart) >= 24*time.Hour {
+		dayCount = 0
+	}
+	return minuteCount, hourCount, dayCount
+}
+
+// dayWindow returns the day counter's count and window start under lock, for
+// the flusher to persist.
+func (u *tokenUsage) dayWindow(now time.Time) (dayCount int, dayStart time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if now.Sub(u.dayStart) >= 24*time.Hour {
+		return 0, now
+	}
+	return u.dayCount, u.dayStart
+}
+
+// tokenRateLimiter is the process-wide, in-memory usage tracker keyed by
+// token ID. A dedicated table or distributed store would be needed for a
+// multi-replica deployment; single-instance is the common case here.
+var tokenRateLimiter = struct {
+	mu    sync.Mutex
+	usage map[string]*tokenUsage
+}{usage: make(map[string]*tokenUsage)}
+
+// usageFor returns the in-memory usage tracker for tokenID, creating one on
+// first use. A freshly created tracker is seeded from the last value this
+// process (or a prior one) flushed to requests_today/usage_window_start, as
+// long as that flush is still within the last 24h - otherwise it starts the
+// token's day window from zero, same as a brand new token would.
+func (s *AnalyticsServer) usageFor(tokenID string) *tokenUsage {
+	tokenRateLimiter.mu.Lock()
+	if u, ok := tokenRateLimiter.usage[tokenID]; ok {
+		tokenRateLimiter.mu.Unlock()
+		return u
+	}
+	tokenRateLimiter.mu.Unlock()
+
+	dayCount, dayStart := s.loadUsageBaseline(tokenID)
+
+	tokenRateLimiter.mu.Lock()
+	defer tokenRateLimiter.mu.Unlock()
+	if u, ok := tokenRateLimiter.usage[tokenID]; ok {
+		return u
+	}
+
+	now := time.Now()
+	u := &tokenUsage{minuteStart: now, hourStart: now, dayStart: dayStart, dayCount: dayCount}
+	tokenRateLimiter.usage[tokenID] = u
+	return u
+}
+
+// loadUsageBaseline reads back the last flushed daily usage for tokenID. It
+// returns a zero count with the window starting now if there's nothing
+// flushed yet, or if what's flushed is more than 24h stale.
+func (s *AnalyticsServer) loadUsageBaseline(tokenID string) (dayCount int, dayStart time.Time) {
+	now := time.Now()
+
+	var requestsToday int
+	var usageWindowStart sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT requests_today, usage_window_start FROM api_tokens WHERE id = ?
+	`, tokenID).Scan(&requestsToday, &usageWindowStart)
+	if err != nil || !usageWindowStart.Valid {
+		return 0, now
+	}
+	if now.Sub(usageWindowStart.Time) >= 24*time.Hour {
+		return 0, now
+	}
+
+	return requestsToday, usageWindowStart.Time
+}
+
+// EnsureRateLimitSchema adds the rate limit and quota columns to
+// api_tokens. Additive and idempotent, same as the other EnsureXSchema
+// helpers.
+func EnsureRateLimitSchema(db *sql.DB) error {
+	statements := []string{
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS rate_limit_per_minute INTEGER`,
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS daily_quota INTEGER`,
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS requests_today INTEGER DEFAULT 0`,
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS usage_window_start TIMESTAMP`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("rate limit schema migration failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkRateLimit counts an admitted request against the token's usage
+// windows and rejects the request once a configured limit is exhausted.
+// Whenever the token has a limit, it sets X-RateLimit-Remaining and
+// X-RateLimit-Reset - for the per-minute window, or for the day window when
+// only a daily quota is set - so clients can self-throttle even before
+// they're rejected. Tokens with no limit get neither header.
+func (s *AnalyticsServer) checkRateLimit(w http.ResponseWriter, token *APIToken) bool {
+	now := time.Now()
+	usage := s.usageFor(token.ID)
+	result := usage.admit(now, token.RateLimitPerMinute, token.DailyQuota)
+
+	if token.RateLimitPerMinute > 0 {
+		remaining := token.RateLimitPerMinute - result.minuteCount
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(maxInt(remaining, 0)))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.minuteReset.Unix(), 10))
+	} else if token.DailyQuota > 0 {
+		remaining := token.DailyQuota - result.dayCount
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(maxInt(remaining, 0)))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.dayReset.Unix(), 10))
+	}
+
+	switch {
+	case result.minuteExceeded:
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(result.minuteReset).Seconds())+1))
+		http.Error(w, "Too Many Requests: per-minute rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	case result.dailyExceeded:
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(result.dayReset).Seconds())+1))
+		http.Error(w, "Too Many Requests: daily quota exceeded", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// StartUsageFlusher launches a background goroutine that periodically
+// persists each tracked token's current day window to requests_today and
+// usage_window_start, so a restarted process can rehydrate it via
+// loadUsageBaseline instead of resetting every token's daily quota usage to
+// zero.
+func StartUsageFlusher(s *AnalyticsServer) {
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.flushUsageCounters()
+		}
+	}()
+}
+
+func (s *AnalyticsServer) flushUsageCounters() {
+	now := time.Now()
+
+	tokenRateLimiter.mu.Lock()
+	trackedIDs := make([]string, 0, len(tokenRateLimiter.usage))
+	trackedUsage := make([]*tokenUsage, 0, len(tokenRateLimiter.usage))
+	for id, u := range tokenRateLimiter.usage {
+		trackedIDs = append(trackedIDs, id)
+		trackedUsage = append(trackedUsage, u)
+	}
+	tokenRateLimiter.mu.Unlock()
+
+	for i, id := range trackedIDs {
+		dayCount, dayStart := trackedUsage[i].dayWindow(now)
+		if _, err := s.db.Exec(`
+			UPDATE api_tokens SET requests_today = ?, usage_window_start = ? WHERE id = ?
+		`, dayCount, dayStart, id); err != nil {
+			log.Printf("Failed to flush usage counter for token %s: %v", id, err)
+		}
+	}
+}
+
+// TokenUsageResponse is returned by GET /api/tokens/{id}/usage.
+type TokenUsageResponse struct {
+	TokenID            string `json:"token_id"`
+	RequestsThisMinute int    `json:"requests_this_minute"`
+	RequestsThisHour   int    `json:"requests_this_hour"`
+	RequestsToday      int    `json:"requests_today"`
+	RateLimitPerMinute int    `json:"rate_limit_per_minute,omitempty"`
+	DailyQuota         int    `json:"daily_quota,omitempty"`
+}
+
+// handleGetTokenUsage reports live usage for a token.
+func (s *AnalyticsServer) handleGetTokenUsage(w http.ResponseWriter, r *http.Request) {
+	tokenID := mux.Vars(r)["id"]
+	if tokenID == "" {
+		http.Error(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var rateLimitPerMinute, dailyQuota sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT rate_limit_per_minute, daily_quota FROM api_tokens WHERE id = ?
+	`, tokenID).Scan(&rateLimitPerMinute, &dailyQuota)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch token", http.StatusInternalServerError)
+		return
+	}
+
+	minuteCount, hourCount, dayCount := s.usageFor(tokenID).snapshot(time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenUsageResponse{
+		TokenID:            tokenID,
+		RequestsThisMinute: minuteCount,
+		RequestsThisHour:   hourCount,
+		RequestsToday:      dayCount,
+		RateLimitPerMinute: int(rateLimitPerMinute.Int64),
+		DailyQuota:         int(dailyQuota.Int64),
+	})
+}
+
+// UpdateTokenLimitsRequest is the body of PATCH /api/tokens/{id}/limits.
+type UpdateTokenLimitsRequest struct {
+	RateLimitPerMinute *int `json:"rate_limit_per_minute,omitempty"`
+	DailyQuota         *int `json:"daily_quota,omitempty"`
+}
+
+// handleUpdateTokenLimits lets an admin tune a token's per-minute rate limit
+// and daily quota.
+func (s *AnalyticsServer) handleUpdateTokenLimits(w http.ResponseWriter, r *http.Request) {
+	tokenID := mux.Vars(r)["id"]
+	if tokenID == "" {
+		http.Error(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateTokenLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.RateLimitPerMinute == nil && req.DailyQuota == nil {
+		http.Error(w, "At least one of rate_limit_per_minute or daily_quota is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE api_tokens
+		SET rate_limit_per_minute = COALESCE(?, rate_limit_per_minute),
+		    daily_quota = COALESCE(?, daily_quota)
+		WHERE id = ?
+	`, req.RateLimitPerMinute, req.DailyQuota, tokenID)
+	if err != nil {
+		http.Error(w, "Failed to update token limits", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		http.Error(w, "Failed to verify update", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Token limits updated successfully",
+	})
+}
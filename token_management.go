@@ -12,7 +12,9 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// CreateTokenRequest represents a request to create a new API token
+// CreateTokenRequest represents a request to create a new API token.
+// Permissions entries are scope strings ("insights:usage:read"), possibly
+// mixed with the legacy flat constants for backwards compatibility.
 type CreateTokenRequest struct {
 	Name        string   `json:"name"`
 	Permissions []string `json:"permissions"`
@@ -21,8 +23,9 @@ type CreateTokenRequest struct {
 
 // CreateTokenResponse represents the response when creating a token
 type CreateTokenResponse struct {
-	Token   string `json:"token"`
-	TokenID string `json:"token_id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenID      string `json:"token_id"`
 	APIToken
 }
 
@@ -69,21 +72,13 @@ func parseExpiresIn(expiresIn string) (*time.Time, error) {
 	return &expiresAt, nil
 }
 
-// validatePermissions checks if the provided permissions are valid
+// validatePermissions checks that every requested permission is either one
+// of the legacy flat constants or a well-formed "resource:target:actions"
+// scope.
 func validatePermissions(permissions []string) error {
-	validPermissions := map[string]bool{
-		PermissionReadInsights: true,
-		PermissionReadHealth:   true,
-		PermissionManageTokens: true,
-		PermissionAdmin:        true,
+	if _, err := parsePermissions(permissions); err != nil {
+		return fmt.Errorf("invalid permission: %v", err)
 	}
-
-	for _, perm := range permissions {
-		if !validPermissions[perm] {
-			return fmt.Errorf("invalid permission: %s", perm)
-		}
-	}
-
 	return nil
 }
 
@@ -122,14 +117,20 @@ func (s *AnalyticsServer) handleCreateToken(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	// Generate token
+	// Generate the initial access/refresh pair
 	token, err := generateSecureToken()
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
+	refreshToken, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		return
+	}
 
 	tokenHash := hashToken(token)
+	accessExpiresAt := time.Now().Add(accessTokenTTL)
 
 	// Convert permissions to JSON
 	permissionsJSON, err := json.Marshal(req.Permissions)
@@ -141,10 +142,10 @@ func (s *AnalyticsServer) handleCreateToken(w http.ResponseWriter, r *http.Reque
 	// Insert into database
 	var tokenID string
 	err = s.db.QueryRow(`
-		INSERT INTO api_tokens (token_hash, name, permissions, expires_at, is_active)
-		VALUES (?, ?, ?, ?, true)
+		INSERT INTO api_tokens (token_hash, name, permissions, expires_at, access_expires_at, refresh_token_hash, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, true)
 		RETURNING id
-	`, tokenHash, req.Name, string(permissionsJSON), expiresAt).Scan(&tokenID)
+	`, tokenHash, req.Name, string(permissionsJSON), expiresAt, accessExpiresAt, hashToken(refreshToken)).Scan(&tokenID)
 
 	if err != nil {
 		http.Error(w, "Failed to create token", http.StatusInternalServerError)
@@ -153,15 +154,17 @@ func (s *AnalyticsServer) handleCreateToken(w http.ResponseWriter, r *http.Reque
 
 	// Prepare response
 	response := CreateTokenResponse{
-		Token:   token,
-		TokenID: tokenID,
+		Token:        token,
+		RefreshToken: refreshToken,
+		TokenID:      tokenID,
 		APIToken: APIToken{
-			ID:          tokenID,
-			Name:        req.Name,
-			Permissions: req.Permissions,
-			CreatedAt:   time.Now(),
-			ExpiresAt:   expiresAt,
-			IsActive:    true,
+			ID:              tokenID,
+			Name:            req.Name,
+			Permissions:     req.Permissions,
+			CreatedAt:       time.Now(),
+			ExpiresAt:       expiresAt,
+			AccessExpiresAt: &accessExpiresAt,
+			IsActive:        true,
 		},
 	}
 
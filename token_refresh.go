@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterRefreshRoute wires up POST /api/tokens/refresh outside
+// APITokenMiddleware. handleRefreshToken authenticates the request itself
+// via the presented refresh token hash, and must stay reachable with an
+// access token that has already expired - going through
+// APITokenMiddleware would reject it with ErrTokenExpired (the one case a
+// client actually needs refresh for), and /api/tokens's routeScopes entry
+// (auth.go) requires tokens:*:manage, which an ordinary non-admin token
+// holder refreshing their own token doesn't have. Register this route
+// directly on the router, not on whatever subrouter APITokenMiddleware
+// wraps.
+func RegisterRefreshRoute(router *mux.Router, s *AnalyticsServer) {
+	router.HandleFunc("/api/tokens/refresh", s.handleRefreshToken).Methods(http.MethodPost)
+}
+
+// accessTokenTTL is how long an issued access token is valid before the
+// client must exchange its refresh token for a new pair.
+const accessTokenTTL = 15 * time.Minute
+
+// sweepInterval controls how often the background sweeper clears stale
+// access-token material.
+const sweepInterval = 10 * time.Minute
+
+// EnsureRefreshTokenSchema adds the columns needed for refresh-token
+// rotation to an existing api_tokens table, and creates the
+// used_refresh_tokens table that records every refresh token a rotation has
+// ever retired. It is additive and idempotent, so it is safe to call on
+// every startup alongside whatever creates the table in the first place.
+func EnsureRefreshTokenSchema(db *sql.DB) error {
+	statements := []string{
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS access_expires_at TIMESTAMP`,
+		`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS refresh_token_hash VARCHAR`,
+		`CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+			token_id VARCHAR NOT NULL,
+			refresh_token_hash VARCHAR NOT NULL,
+			used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("refresh token schema migration failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// RefreshTokenRequest is the body of POST /api/tokens/refresh.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenResponse carries the rotated access/refresh pair.
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// handleRefreshToken rotates a refresh token: the presented refresh token is
+// invalidated and a fresh access/refresh pair is issued in its place. If a
+// refresh token is presented that was already rotated away, the whole token
+// is treated as compromised and revoked.
+func (s *AnalyticsServer) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	presentedHash := hashToken(req.RefreshToken)
+
+	accessToken, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	accessExpiresAt := time.Now().Add(accessTokenTTL)
+
+	// The rotation itself is a single compare-and-swap UPDATE keyed on the
+	// presented hash still being the token's current refresh_token_hash, so
+	// two concurrent refreshes of the same token can't both succeed: only
+	// the first to commit matches the WHERE clause, and RETURNING id tells
+	// us which token it was without a separate, racy SELECT-then-UPDATE.
+	var tokenID string
+	err = s.db.QueryRow(`
+		UPDATE api_tokens
+		SET token_hash = ?, access_expires_at = ?, refresh_token_hash = ?
+		WHERE refresh_token_hash = ? AND is_active = true
+		RETURNING id
+	`, hashToken(accessToken), accessExpiresAt, hashToken(refreshToken), presentedHash).Scan(&tokenID)
+
+	if err == sql.ErrNoRows {
+		if s.revokeOnRefreshReuse(r, presentedHash) {
+			http.Error(w, "Unauthorized: refresh token reuse detected, token revoked", http.StatusUnauthorized)
+			return
+		}
+		s.logAuthFailure(r, "Invalid refresh token")
+		http.Error(w, "Unauthorized: invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to rotate token", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO used_refresh_tokens (token_id, refresh_token_hash) VALUES (?, ?)
+	`, tokenID, presentedHash); err != nil {
+		log.Printf("Failed to record used refresh token for %s: %v", tokenID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RefreshTokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	})
+}
+
+// revokeOnRefreshReuse checks whether presentedHash matches any refresh
+// token hash this token has ever rotated away, not just the immediately
+// prior one - used_refresh_tokens keeps the whole family's history, so
+// reuse is still caught after several more rotations have happened since
+// the token leaked. If a match is found, the whole token is revoked and a
+// security event is logged - a refresh token should only ever be presented
+// once, so a repeat presentation means it leaked.
+func (s *AnalyticsServer) revokeOnRefreshReuse(r *http.Request, presentedHash string) bool {
+	var tokenID, name string
+	err := s.db.QueryRow(`
+		SELECT api_tokens.id, api_tokens.name
+		FROM used_refresh_tokens
+		JOIN api_tokens ON api_tokens.id = used_refresh_tokens.token_id
+		WHERE used_refresh_tokens.refresh_token_hash = ? AND api_tokens.is_active = true
+	`, presentedHash).Scan(&tokenID, &name)
+	if err != nil {
+		return false
+	}
+
+	if _, err := s.db.Exec(`UPDATE api_tokens SET is_active = false WHERE id = ?`, tokenID); err != nil {
+		log.Printf("Failed to revoke token %s after refresh reuse: %v", tokenID, err)
+	}
+
+	s.logAuthFailure(r, fmt.Sprintf("SECURITY: refresh token reuse detected for token %q (%s) - token family revoked", name, tokenID))
+	return true
+}
+
+// StartTokenSweeper launches a background goroutine that periodically clears
+// expired access-token hashes, so a stale, already-expired token_hash can't
+// linger in the database beyond its usefulness. It does not touch
+// refresh_token_hash or is_active - only a client calling /refresh (or an
+// admin revoking the token) should end a token's life.
+func StartTokenSweeper(s *AnalyticsServer) {
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.sweepExpiredAccessTokens(); err != nil {
+				log.Printf("Token sweeper: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *AnalyticsServer) sweepExpiredAccessTokens() error {
+	// token_hash is a natural unique key, so clearing it to a shared ''
+	// sentinel across every expired row in one UPDATE fails the moment two
+	// tokens are expired at once. NULL, unlike '', doesn't collide with
+	// itself under a UNIQUE constraint, so it's safe to assign to every
+	// matching row in a single statement.
+	_, err := s.db.Exec(`
+		UPDATE api_tokens
+		SET token_hash = NULL
+		WHERE access_expires_at IS NOT NULL AND access_expires_at < CURRENT_TIMESTAMP AND token_hash IS NOT NULL
+	`)
+	return err
+}